@@ -0,0 +1,399 @@
+// Copyright (c) 2014 The gomqtt Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// A CoAPCode is the method or response code of a CoAP message (RFC 7252
+// sections 5.8 and 5.9).
+type CoAPCode uint8
+
+// A selection of CoAP codes used by callers of CoAPCodec.
+const (
+	CoAPCodeGET     CoAPCode = 0x01
+	CoAPCodePOST    CoAPCode = 0x02
+	CoAPCodeContent CoAPCode = 0x45 // 2.05 Content
+	CoAPCodeCSM     CoAPCode = 0xE1 // 7.01 Capabilities and Settings Message (RFC 8323)
+)
+
+// coapContentFormatOption is the option number of the Content-Format
+// option (RFC 7252 section 5.10.3).
+const coapContentFormatOption = 12
+
+// A CoAPOption is a single CoAP option (RFC 7252 section 3.1).
+type CoAPOption struct {
+	Number uint16
+	Value  []byte
+}
+
+// A CoAPPacket is a CoAP message framed for CoAP-over-TCP/WebSocket
+// (RFC 8323). Unlike CoAP-over-UDP it carries no message ID or type, just
+// a token, a code, options and an optional payload.
+type CoAPPacket struct {
+	Code    CoAPCode
+	Token   []byte
+	Options []CoAPOption
+	Payload []byte
+}
+
+// ContentFormat returns the value of the Content-Format option, if set.
+func (p *CoAPPacket) ContentFormat() (format uint16, ok bool) {
+	for _, o := range p.Options {
+		if o.Number == coapContentFormatOption {
+			for _, b := range o.Value {
+				format = format<<8 | uint16(b)
+			}
+
+			return format, true
+		}
+	}
+
+	return 0, false
+}
+
+// SetContentFormat sets the Content-Format option.
+func (p *CoAPPacket) SetContentFormat(format uint16) {
+	var value []byte
+
+	switch {
+	case format == 0:
+		value = nil
+	case format < 256:
+		value = []byte{byte(format)}
+	default:
+		value = []byte{byte(format >> 8), byte(format)}
+	}
+
+	for i, o := range p.Options {
+		if o.Number == coapContentFormatOption {
+			p.Options[i].Value = value
+			return
+		}
+	}
+
+	p.Options = append(p.Options, CoAPOption{Number: coapContentFormatOption, Value: value})
+}
+
+// Len returns the encoded length of the packet.
+func (p *CoAPPacket) Len() int {
+	buf, err := encodeCoAPMessage(p)
+	if err != nil {
+		return 0
+	}
+
+	return len(buf)
+}
+
+// CoAPCodec implements Codec for CoAP-over-TCP/WebSocket framing as
+// defined by RFC 8323.
+type CoAPCodec struct{}
+
+// Detect implements Codec.
+func (CoAPCodec) Detect(buf []byte) (int, error) {
+	return detectCoAPMessageLength(buf)
+}
+
+// Decode implements Codec.
+func (c CoAPCodec) Decode(r io.Reader, limit int64) (Packet, int, error) {
+	header := make([]byte, 0, 3)
+
+	for {
+		b, err := readByte(r)
+		if err != nil {
+			return nil, len(header), err
+		}
+
+		header = append(header, b)
+
+		length, err := c.Detect(header)
+		if err != nil {
+			return nil, len(header), newTransportError(DetectionError, err)
+		}
+
+		if length == 0 {
+			continue
+		}
+
+		if limit > 0 && int64(length) > limit {
+			return nil, len(header), newTransportError(ReadLimitExceeded, io.ErrShortBuffer)
+		}
+
+		buf := make([]byte, length)
+		copy(buf, header)
+
+		if m, err := io.ReadFull(r, buf[len(header):]); err != nil {
+			return nil, len(header) + m, err
+		}
+
+		pkt, err := decodeCoAPMessage(buf)
+		if err != nil {
+			return nil, length, newTransportError(DecodeError, err)
+		}
+
+		return pkt, length, nil
+	}
+}
+
+// Encode implements Codec.
+func (CoAPCodec) Encode(w io.Writer, pkt Packet) (int, error) {
+	coapPkt, ok := pkt.(*CoAPPacket)
+	if !ok {
+		return 0, newTransportError(EncodeError, fmt.Errorf("coap: %T is not a CoAP packet", pkt))
+	}
+
+	buf, err := encodeCoAPMessage(coapPkt)
+	if err != nil {
+		return 0, newTransportError(EncodeError, err)
+	}
+
+	return w.Write(buf)
+}
+
+// detectCoAPMessageLength returns the total frame length (including the
+// header) once buf holds a complete RFC 8323 header, or zero if more bytes
+// are needed.
+func detectCoAPMessageLength(buf []byte) (int, error) {
+	if len(buf) < 1 {
+		return 0, nil
+	}
+
+	lengthNibble := int(buf[0] >> 4)
+	tokenLength := int(buf[0] & 0x0f)
+
+	if tokenLength > 8 {
+		return 0, errors.New("coap: token length exceeds 8 bytes")
+	}
+
+	headerLength := 1
+	var tail int
+
+	switch {
+	case lengthNibble < 13:
+		tail = lengthNibble
+	case lengthNibble == 13:
+		if len(buf) < 2 {
+			return 0, nil
+		}
+
+		tail = int(buf[1]) + 13
+		headerLength = 2
+	case lengthNibble == 14:
+		if len(buf) < 3 {
+			return 0, nil
+		}
+
+		tail = int(binary.BigEndian.Uint16(buf[1:3])) + 269
+		headerLength = 3
+	default:
+		return 0, errors.New("coap: reserved length nibble")
+	}
+
+	// + 1 for the code byte that follows the (extended) header.
+	return headerLength + 1 + tokenLength + tail, nil
+}
+
+// encodeCoAPMessage encodes pkt into a full RFC 8323 frame.
+func encodeCoAPMessage(pkt *CoAPPacket) ([]byte, error) {
+	if len(pkt.Token) > 8 {
+		return nil, errors.New("coap: token length exceeds 8 bytes")
+	}
+
+	optionBytes := encodeCoAPOptions(pkt.Options)
+
+	tail := len(optionBytes)
+	if len(pkt.Payload) > 0 {
+		tail += 1 + len(pkt.Payload)
+	}
+
+	var header []byte
+
+	switch {
+	case tail < 13:
+		header = []byte{byte(tail<<4) | byte(len(pkt.Token))}
+	case tail < 269:
+		header = []byte{byte(13<<4) | byte(len(pkt.Token)), byte(tail - 13)}
+	case tail < 65805:
+		header = make([]byte, 3)
+		header[0] = byte(14<<4) | byte(len(pkt.Token))
+		binary.BigEndian.PutUint16(header[1:], uint16(tail-269))
+	default:
+		return nil, errors.New("coap: message too large to frame")
+	}
+
+	buf := make([]byte, 0, len(header)+1+len(pkt.Token)+tail)
+	buf = append(buf, header...)
+	buf = append(buf, byte(pkt.Code))
+	buf = append(buf, pkt.Token...)
+	buf = append(buf, optionBytes...)
+
+	if len(pkt.Payload) > 0 {
+		buf = append(buf, 0xff)
+		buf = append(buf, pkt.Payload...)
+	}
+
+	return buf, nil
+}
+
+// decodeCoAPMessage decodes a full RFC 8323 frame, which must be exactly
+// as long as detectCoAPMessageLength reported.
+func decodeCoAPMessage(buf []byte) (*CoAPPacket, error) {
+	length, err := detectCoAPMessageLength(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	if length != len(buf) {
+		return nil, errors.New("coap: truncated message")
+	}
+
+	lengthNibble := int(buf[0] >> 4)
+	tokenLength := int(buf[0] & 0x0f)
+
+	offset := 1
+	if lengthNibble == 13 {
+		offset = 2
+	} else if lengthNibble == 14 {
+		offset = 3
+	}
+
+	code := CoAPCode(buf[offset])
+	offset++
+
+	token := append([]byte(nil), buf[offset:offset+tokenLength]...)
+	offset += tokenLength
+
+	options, payload, err := decodeCoAPOptions(buf[offset:])
+	if err != nil {
+		return nil, err
+	}
+
+	return &CoAPPacket{
+		Code:    code,
+		Token:   token,
+		Options: options,
+		Payload: payload,
+	}, nil
+}
+
+// encodeCoAPOptions encodes options, which must already be sorted
+// ascending by Number, using the RFC 7252 section 3.1 delta/length
+// nibble scheme.
+func encodeCoAPOptions(options []CoAPOption) []byte {
+	var buf []byte
+
+	prev := 0
+
+	for _, o := range options {
+		deltaNibble, deltaExt := splitCoAPOptionValue(int(o.Number) - prev)
+		prev = int(o.Number)
+
+		lengthNibble, lengthExt := splitCoAPOptionValue(len(o.Value))
+
+		buf = append(buf, byte(deltaNibble<<4)|byte(lengthNibble))
+		buf = append(buf, deltaExt...)
+		buf = append(buf, lengthExt...)
+		buf = append(buf, o.Value...)
+	}
+
+	return buf
+}
+
+// decodeCoAPOptions decodes the options and trailing payload (if any) that
+// follow a CoAP message's token.
+func decodeCoAPOptions(buf []byte) ([]CoAPOption, []byte, error) {
+	var options []CoAPOption
+
+	prev := 0
+	i := 0
+
+	for i < len(buf) {
+		if buf[i] == 0xff {
+			return options, buf[i+1:], nil
+		}
+
+		deltaNibble := int(buf[i] >> 4)
+		lengthNibble := int(buf[i] & 0x0f)
+		i++
+
+		delta, n, err := extendCoAPOptionValue(deltaNibble, buf[i:])
+		if err != nil {
+			return nil, nil, err
+		}
+		i += n
+
+		length, n, err := extendCoAPOptionValue(lengthNibble, buf[i:])
+		if err != nil {
+			return nil, nil, err
+		}
+		i += n
+
+		if i+length > len(buf) {
+			return nil, nil, errors.New("coap: truncated option")
+		}
+
+		prev += delta
+		options = append(options, CoAPOption{
+			Number: uint16(prev),
+			Value:  append([]byte(nil), buf[i:i+length]...),
+		})
+		i += length
+	}
+
+	return options, nil, nil
+}
+
+// splitCoAPOptionValue encodes v as an option delta or length nibble plus
+// any extended bytes, per RFC 7252 section 3.1.
+func splitCoAPOptionValue(v int) (nibble int, ext []byte) {
+	switch {
+	case v < 13:
+		return v, nil
+	case v < 269:
+		return 13, []byte{byte(v - 13)}
+	default:
+		ext = make([]byte, 2)
+		binary.BigEndian.PutUint16(ext, uint16(v-269))
+		return 14, ext
+	}
+}
+
+// extendCoAPOptionValue decodes a delta or length nibble plus any extended
+// bytes back into a value, returning how many extended bytes were
+// consumed.
+func extendCoAPOptionValue(nibble int, rest []byte) (value int, consumed int, err error) {
+	switch {
+	case nibble < 13:
+		return nibble, 0, nil
+	case nibble == 13:
+		if len(rest) < 1 {
+			return 0, 0, errors.New("coap: truncated option")
+		}
+
+		return int(rest[0]) + 13, 1, nil
+	case nibble == 14:
+		if len(rest) < 2 {
+			return 0, 0, errors.New("coap: truncated option")
+		}
+
+		return int(binary.BigEndian.Uint16(rest[:2])) + 269, 2, nil
+	default:
+		return 0, 0, errors.New("coap: reserved option nibble")
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2014 The gomqtt Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCoAPPacketContentFormat(t *testing.T) {
+	pkt := &CoAPPacket{Code: CoAPCodeContent}
+
+	_, ok := pkt.ContentFormat()
+	require.False(t, ok)
+
+	pkt.SetContentFormat(50) // application/json
+
+	format, ok := pkt.ContentFormat()
+	require.True(t, ok)
+	require.Equal(t, uint16(50), format)
+}
+
+func TestCoAPCodecRoundTrip(t *testing.T) {
+	codec := CoAPCodec{}
+
+	pkt := &CoAPPacket{
+		Code:    CoAPCodeContent,
+		Token:   []byte{0x13, 0x37},
+		Payload: []byte("hello"),
+	}
+	pkt.SetContentFormat(50)
+
+	var buf bytes.Buffer
+
+	n, err := codec.Encode(&buf, pkt)
+	require.NoError(t, err)
+	require.Equal(t, buf.Len(), n)
+	require.Equal(t, pkt.Len(), n)
+
+	decoded, m, err := codec.Decode(bytes.NewReader(buf.Bytes()), 0)
+	require.NoError(t, err)
+	require.Equal(t, n, m)
+
+	coapPkt, ok := decoded.(*CoAPPacket)
+	require.True(t, ok)
+	require.Equal(t, pkt.Code, coapPkt.Code)
+	require.Equal(t, pkt.Token, coapPkt.Token)
+	require.Equal(t, pkt.Payload, coapPkt.Payload)
+
+	format, ok := coapPkt.ContentFormat()
+	require.True(t, ok)
+	require.Equal(t, uint16(50), format)
+}
+
+func TestCoAPCodecReadLimitExceeded(t *testing.T) {
+	codec := CoAPCodec{}
+
+	pkt := &CoAPPacket{Code: CoAPCodeContent, Payload: make([]byte, 64)}
+
+	var buf bytes.Buffer
+	_, err := codec.Encode(&buf, pkt)
+	require.NoError(t, err)
+
+	_, _, err = codec.Decode(bytes.NewReader(buf.Bytes()), 8)
+	require.Equal(t, ReadLimitExceeded, toError(err).Code())
+}
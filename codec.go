@@ -0,0 +1,184 @@
+// Copyright (c) 2014 The gomqtt Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/gomqtt/packet"
+)
+
+// A Packet is a single decoded message as produced and consumed by a
+// Codec. It is satisfied by github.com/gomqtt/packet.Packet (via
+// MQTTCodec) as well as CoAPPacket (via CoAPCodec).
+type Packet interface {
+	// Len returns the encoded length of the packet.
+	Len() int
+}
+
+// A Codec knows how to detect, decode and encode the packets of a specific
+// wire protocol, letting NetConn and WebSocketConn stay protocol agnostic.
+type Codec interface {
+	// Detect inspects buf, the bytes read so far for the next packet, and
+	// returns the total length the packet will have once complete. It
+	// returns a length of zero if buf does not yet hold enough bytes to
+	// tell, and an error if buf can never be a valid packet header.
+	Detect(buf []byte) (length int, err error)
+
+	// Decode reads exactly one packet from r and decodes it. If limit is
+	// greater than zero and the packet would exceed it, Decode returns a
+	// TransportError with code ReadLimitExceeded without reading the full
+	// payload. The returned n is the number of bytes consumed from r,
+	// valid even when err is non-nil.
+	Decode(r io.Reader, limit int64) (pkt Packet, n int, err error)
+
+	// Encode encodes pkt and writes it to w, returning the number of
+	// bytes written.
+	Encode(w io.Writer, pkt Packet) (n int, err error)
+}
+
+// MQTTCodec implements Codec for the MQTT wire format using
+// github.com/gomqtt/packet. It is the default Codec used by NewNetConn and
+// NewWebSocketConn.
+type MQTTCodec struct{}
+
+// Detect implements Codec.
+func (MQTTCodec) Detect(buf []byte) (int, error) {
+	return packet.DetectPacket(buf)
+}
+
+// Decode implements Codec.
+func (c MQTTCodec) Decode(r io.Reader, limit int64) (Packet, int, error) {
+	header := make([]byte, 0, 5)
+
+	for {
+		b, err := readByte(r)
+		if err != nil {
+			return nil, len(header), err
+		}
+
+		header = append(header, b)
+
+		length, err := c.Detect(header)
+		if err != nil {
+			return nil, len(header), newTransportError(DetectionError, err)
+		}
+
+		if length == 0 {
+			continue
+		}
+
+		if limit > 0 && int64(length) > limit {
+			return nil, len(header), newTransportError(ReadLimitExceeded, io.ErrShortBuffer)
+		}
+
+		buf := make([]byte, length)
+		copy(buf, header)
+
+		if m, err := io.ReadFull(r, buf[len(header):]); err != nil {
+			return nil, len(header) + m, err
+		}
+
+		pkt, err := decodeMQTTPacket(buf)
+		if err != nil {
+			return nil, length, newTransportError(DecodeError, err)
+		}
+
+		return pkt, length, nil
+	}
+}
+
+// encodeBufferPool holds the scratch buffers used by MQTTCodec.Encode,
+// saving an allocation on the hot path of a broker pushing many small
+// packets. Buffers grow to fit the largest recently encoded packet and
+// are reused from then on.
+var encodeBufferPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 0, 128)
+		return &buf
+	},
+}
+
+// Encode implements Codec.
+func (MQTTCodec) Encode(w io.Writer, pkt Packet) (int, error) {
+	mqttPkt, ok := pkt.(packet.Packet)
+	if !ok {
+		return 0, newTransportError(EncodeError, fmt.Errorf("mqtt: %T is not an MQTT packet", pkt))
+	}
+
+	bufp := encodeBufferPool.Get().(*[]byte)
+	defer encodeBufferPool.Put(bufp)
+
+	buf, err := encodeMQTTPacket(mqttPkt, *bufp)
+	if err != nil {
+		return 0, newTransportError(EncodeError, err)
+	}
+
+	*bufp = buf[:0]
+
+	return w.Write(buf)
+}
+
+// decodeMQTTPacket allocates a packet matching the type encoded in buf and
+// decodes the rest of the message into it.
+func decodeMQTTPacket(buf []byte) (packet.Packet, error) {
+	pkt, err := packet.New(packet.Type(buf[0] >> 4))
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = pkt.Decode(buf); err != nil {
+		return nil, err
+	}
+
+	return pkt, nil
+}
+
+// encodeMQTTPacket encodes pkt into scratch, growing it if it is too small
+// to fit the packet, and returns the result.
+func encodeMQTTPacket(pkt packet.Packet, scratch []byte) ([]byte, error) {
+	size := pkt.Len()
+
+	if cap(scratch) < size {
+		scratch = make([]byte, size)
+	} else {
+		scratch = scratch[:size]
+	}
+
+	n, err := pkt.Encode(scratch)
+	if err != nil {
+		return nil, err
+	}
+
+	return scratch[:n], nil
+}
+
+// readByte reads a single byte from r, using its ReadByte method when
+// available to avoid defeating any internal buffering.
+func readByte(r io.Reader) (byte, error) {
+	if br, ok := r.(io.ByteReader); ok {
+		return br.ReadByte()
+	}
+
+	var buf [1]byte
+
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return 0, err
+	}
+
+	return buf[0], nil
+}
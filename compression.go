@@ -0,0 +1,33 @@
+// Copyright (c) 2014 The gomqtt Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+// CompressionOptions configures the permessage-deflate WebSocket extension
+// (RFC 7692) for a Dialer or Server. It only has an effect on WebSocket
+// connections; plain TCP/TLS connections ignore it.
+type CompressionOptions struct {
+	// Enabled negotiates permessage-deflate during the WebSocket handshake.
+	Enabled bool
+
+	// Level sets the flate compression level used when writing messages
+	// (see compress/flate). Zero uses the flate default.
+	//
+	// Context takeover and LZ77 window size are not exposed here: the
+	// underlying gorilla/websocket implementation always negotiates
+	// client_no_context_takeover and server_no_context_takeover, and does
+	// not support negotiating a non-default window size, so there is
+	// nothing a caller-supplied value could change.
+	Level int
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2014 The gomqtt Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package transport implements connection oriented transports for sending
+// and receiving packets, by default MQTT but pluggable via Codec.
+package transport
+
+import (
+	"context"
+	"time"
+)
+
+// A Handler processes a single accepted or dialed Conn.
+type Handler func(Conn)
+
+// A Conn is a connection that sends and receives packets using a Codec.
+//
+// Implementations are provided for raw TCP/TLS connections (NetConn) and
+// WebSocket connections (WebSocketConn).
+type Conn interface {
+	// Send will encode and write the packet to the underlying connection.
+	// It will return an Error if there was an error while encoding or
+	// writing the packet. It is equivalent to calling SendContext with
+	// context.Background().
+	Send(pkt Packet) error
+
+	// SendContext is like Send but aborts with a TransportError of code
+	// ContextCanceled if ctx is done before the packet has been fully
+	// written.
+	SendContext(ctx context.Context, pkt Packet) error
+
+	// Receive will read and decode the next packet from the underlying
+	// connection. It will return an Error if there was an error while
+	// reading or decoding the packet. It is equivalent to calling
+	// ReceiveContext with context.Background().
+	Receive() (Packet, error)
+
+	// ReceiveContext is like Receive but aborts with a TransportError of
+	// code ContextCanceled if ctx is done before a packet has been fully
+	// read.
+	ReceiveContext(ctx context.Context) (Packet, error)
+
+	// Close will close the underlying connection.
+	Close() error
+
+	// BytesRead returns the number of bytes already read from the
+	// underlying connection. For a WebSocketConn that negotiated
+	// permessage-deflate, this is the decompressed packet-payload size
+	// rather than the smaller compressed size that actually crossed the
+	// wire, so that the counter means the same thing — bytes of packet
+	// data transferred — across every Conn implementation.
+	BytesRead() int64
+
+	// BytesWritten returns the number of bytes already written to the
+	// underlying connection. See BytesRead for how this relates to wire
+	// size on a compressed WebSocketConn.
+	BytesWritten() int64
+
+	// SetReadLimit sets the maximum size of a packet that can be read
+	// from the underlying connection. If the limit is greater than zero
+	// and a bigger packet is received, Receive returns a TransportError
+	// with code ReadLimitExceeded and closes the connection.
+	SetReadLimit(limit int64)
+
+	// Flush writes any packets buffered by WriteBuffer to the underlying
+	// connection. It is a no-op on a connection that has no write buffer
+	// installed.
+	Flush() error
+
+	// SetKeepAlive enables keepalive: once the write side has been idle
+	// for interval, a keepalive ping is sent (a PINGREQ for NetConn, a
+	// WebSocket ping control frame for WebSocketConn), and the connection
+	// is closed with a TransportError of code KeepAliveTimeout if no
+	// response, or any other inbound activity, arrives within timeout of
+	// that ping.
+	SetKeepAlive(interval, timeout time.Duration)
+}
@@ -0,0 +1,60 @@
+// Copyright (c) 2014 The gomqtt Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNetConnReceiveContextCancel(t *testing.T) {
+	rawConn1, rawConn2 := net.Pipe()
+	defer rawConn2.Close()
+
+	conn1 := NewNetConn(rawConn1)
+	defer conn1.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := conn1.ReceiveContext(ctx)
+		result <- err
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	err := <-result
+	require.Equal(t, ContextCanceled, toError(err).Code())
+}
+
+func TestNetConnReceiveContextDeadlineExceeded(t *testing.T) {
+	rawConn1, rawConn2 := net.Pipe()
+	defer rawConn2.Close()
+
+	conn1 := NewNetConn(rawConn1)
+	defer conn1.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := conn1.ReceiveContext(ctx)
+	require.Equal(t, ContextCanceled, toError(err).Code())
+}
@@ -16,6 +16,7 @@ package transport
 
 import (
 	"testing"
+	"time"
 
 	"github.com/gomqtt/packet"
 	"github.com/stretchr/testify/require"
@@ -24,13 +25,57 @@ import (
 
 type abstractTestPreparer func (Handler) (Conn, chan struct{})
 
-func abstractConnConnectTest(t *testing.T, preparer abstractTestPreparer) {
+// abstractCodecFixture supplies the codec-specific pieces (building a
+// request/response packet pair and telling them apart) that the abstract
+// conn tests below need but cannot derive from a Codec alone. mqttFixture
+// and coapFixture let the same tests run against both MQTTCodec and
+// CoAPCodec.
+type abstractCodecFixture struct {
+	newRequestPacket  func() Packet
+	newResponsePacket func() Packet
+	isRequestPacket   func(Packet) bool
+	isResponsePacket  func(Packet) bool
+}
+
+var mqttFixture = abstractCodecFixture{
+	newRequestPacket:  func() Packet { return packet.NewConnectPacket() },
+	newResponsePacket: func() Packet { return packet.NewConnackPacket() },
+	isRequestPacket: func(pkt Packet) bool {
+		mqttPkt, ok := pkt.(packet.Packet)
+		return ok && mqttPkt.Type() == packet.CONNECT
+	},
+	isResponsePacket: func(pkt Packet) bool {
+		mqttPkt, ok := pkt.(packet.Packet)
+		return ok && mqttPkt.Type() == packet.CONNACK
+	},
+}
+
+var coapFixture = abstractCodecFixture{
+	newRequestPacket: func() Packet {
+		return &CoAPPacket{Code: CoAPCodeGET, Token: []byte{0x13, 0x37}}
+	},
+	newResponsePacket: func() Packet {
+		pkt := &CoAPPacket{Code: CoAPCodeContent, Token: []byte{0x13, 0x37}, Payload: []byte("hello")}
+		pkt.SetContentFormat(50)
+		return pkt
+	},
+	isRequestPacket: func(pkt Packet) bool {
+		coapPkt, ok := pkt.(*CoAPPacket)
+		return ok && coapPkt.Code == CoAPCodeGET
+	},
+	isResponsePacket: func(pkt Packet) bool {
+		coapPkt, ok := pkt.(*CoAPPacket)
+		return ok && coapPkt.Code == CoAPCodeContent
+	},
+}
+
+func abstractConnConnectTest(t *testing.T, preparer abstractTestPreparer, fx abstractCodecFixture) {
 	conn2, done := preparer(func(conn1 Conn){
 		pkt, err := conn1.Receive()
-		require.Equal(t, pkt.Type(), packet.CONNECT)
+		require.True(t, fx.isRequestPacket(pkt))
 		require.NoError(t, err)
 
-		err = conn1.Send(packet.NewConnackPacket())
+		err = conn1.Send(fx.newResponsePacket())
 		require.NoError(t, err)
 
 		pkt, err = conn1.Receive()
@@ -38,11 +83,11 @@ func abstractConnConnectTest(t *testing.T, preparer abstractTestPreparer) {
 		require.Equal(t, ExpectedClose, toError(err).Code())
 	})
 
-	err := conn2.Send(packet.NewConnectPacket())
+	err := conn2.Send(fx.newRequestPacket())
 	require.NoError(t, err)
 
 	pkt, err := conn2.Receive()
-	require.Equal(t, pkt.Type(), packet.CONNACK)
+	require.True(t, fx.isResponsePacket(pkt))
 	require.NoError(t, err)
 
 	err = conn2.Close()
@@ -162,19 +207,19 @@ func abstractConnSendAfterCloseTest(t *testing.T, preparer abstractTestPreparer)
 	<-done
 }
 
-func abstractConnCountersTest(t *testing.T, preparer abstractTestPreparer) {
+func abstractConnCountersTest(t *testing.T, preparer abstractTestPreparer, fx abstractCodecFixture) {
 	conn2, done := preparer(func(conn1 Conn){
 		pkt, _ := conn1.Receive()
 		require.Equal(t, int64(pkt.Len()), conn1.BytesRead())
 
-		pkt2 := packet.NewConnackPacket()
+		pkt2 := fx.newResponsePacket()
 		conn1.Send(pkt2)
 		require.Equal(t, int64(pkt2.Len()), conn1.BytesWritten())
 
 		conn1.Close()
 	})
 
-	pkt := packet.NewConnectPacket()
+	pkt := fx.newRequestPacket()
 	conn2.Send(pkt)
 	require.Equal(t, int64(pkt.Len()), conn2.BytesWritten())
 
@@ -185,7 +230,34 @@ func abstractConnCountersTest(t *testing.T, preparer abstractTestPreparer) {
 	<-done
 }
 
-func abstractConnReadLimitTest(t *testing.T, preparer abstractTestPreparer) {
+func abstractConnKeepAliveTest(t *testing.T, preparer abstractTestPreparer) {
+	conn2, done := preparer(func(conn1 Conn) {
+		// a half-open peer: keep draining conn2's keepalive pings so Send
+		// never blocks on them, but never answer any of them.
+		if webSocketConn, ok := conn1.(*WebSocketConn); ok {
+			webSocketConn.conn.SetPingHandler(func(string) error { return nil })
+		}
+
+		for {
+			if _, err := conn1.Receive(); err != nil {
+				return
+			}
+		}
+	})
+
+	conn2.SetKeepAlive(20*time.Millisecond, 50*time.Millisecond)
+
+	start := time.Now()
+
+	pkt, err := conn2.Receive()
+	require.Nil(t, pkt)
+	require.Equal(t, KeepAliveTimeout, toError(err).Code())
+	require.Less(t, time.Since(start), time.Second)
+
+	<-done
+}
+
+func abstractConnReadLimitTest(t *testing.T, preparer abstractTestPreparer, fx abstractCodecFixture) {
 	conn2, done := preparer(func(conn1 Conn){
 		conn1.SetReadLimit(1)
 
@@ -194,7 +266,7 @@ func abstractConnReadLimitTest(t *testing.T, preparer abstractTestPreparer) {
 		require.Equal(t, ReadLimitExceeded, toError(err).Code())
 	})
 
-	err := conn2.Send(packet.NewConnectPacket())
+	err := conn2.Send(fx.newRequestPacket())
 	require.NoError(t, err)
 
 	pkt, err := conn2.Receive()
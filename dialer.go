@@ -0,0 +1,129 @@
+// Copyright (c) 2014 The gomqtt Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// A Dialer establishes outgoing Conns to tcp, tls, ws and wss URLs.
+type Dialer struct {
+	// TLSConfig is used for tls and wss connections.
+	TLSConfig *tls.Config
+
+	// Timeout bounds the time spent dialing and, for WebSocket
+	// connections, completing the handshake.
+	Timeout time.Duration
+
+	// Compression configures the permessage-deflate extension for ws and
+	// wss connections. A nil value disables it.
+	Compression *CompressionOptions
+
+	// Codec selects the wire protocol spoken over the connection. A nil
+	// value defaults to MQTTCodec{}.
+	Codec Codec
+}
+
+// NewDialer returns a Dialer with sane defaults.
+func NewDialer() *Dialer {
+	return &Dialer{
+		Timeout: 10 * time.Second,
+	}
+}
+
+// Dial connects to the broker reachable under the given URL. The scheme
+// must be one of tcp, tls (or its aliases ssl and tcps), ws or wss.
+func (d *Dialer) Dial(urlString string) (Conn, error) {
+	u, err := url.ParseRequestURI(urlString)
+	if err != nil {
+		return nil, newTransportError(DialError, err)
+	}
+
+	switch u.Scheme {
+	case "tcp":
+		return d.dialTCP(u.Host, nil)
+	case "tls", "ssl", "tcps":
+		return d.dialTCP(u.Host, d.TLSConfig)
+	case "ws":
+		return d.dialWS(urlString, nil)
+	case "wss":
+		return d.dialWS(urlString, d.TLSConfig)
+	default:
+		return nil, newTransportError(DialError, fmt.Errorf("unsupported scheme %q", u.Scheme))
+	}
+}
+
+// dialTCP dials a plain or TLS secured TCP connection.
+func (d *Dialer) dialTCP(addr string, tlsConfig *tls.Config) (Conn, error) {
+	var conn net.Conn
+	var err error
+
+	if tlsConfig != nil {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: d.Timeout}, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = net.DialTimeout("tcp", addr, d.Timeout)
+	}
+
+	if err != nil {
+		return nil, newTransportError(DialError, err)
+	}
+
+	return NewNetConnWithCodec(conn, d.codec()), nil
+}
+
+// codec returns the configured Codec, defaulting to MQTTCodec{}.
+func (d *Dialer) codec() Codec {
+	if d.Codec != nil {
+		return d.Codec
+	}
+
+	return MQTTCodec{}
+}
+
+// dialWS dials a WebSocket connection, optionally negotiating
+// permessage-deflate.
+func (d *Dialer) dialWS(urlString string, tlsConfig *tls.Config) (Conn, error) {
+	dialer := &websocket.Dialer{
+		HandshakeTimeout: d.Timeout,
+		TLSClientConfig:  tlsConfig,
+		Subprotocols:     []string{"mqtt"},
+		NetDial: func(network, addr string) (net.Conn, error) {
+			return net.DialTimeout(network, addr, d.Timeout)
+		},
+	}
+
+	if d.Compression != nil && d.Compression.Enabled {
+		dialer.EnableCompression = true
+	}
+
+	conn, resp, err := dialer.Dial(urlString, nil)
+	if err != nil {
+		return nil, newTransportError(DialError, err)
+	}
+
+	compressionEnabled := d.Compression != nil && d.Compression.Enabled && resp.Header.Get("Sec-WebSocket-Extensions") != ""
+
+	if compressionEnabled && d.Compression.Level != 0 {
+		conn.SetCompressionLevel(d.Compression.Level)
+	}
+
+	return NewWebSocketConnWithCodec(conn, compressionEnabled, d.codec()), nil
+}
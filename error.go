@@ -0,0 +1,144 @@
+// Copyright (c) 2014 The gomqtt Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import "fmt"
+
+// A Code denotes the kind of error that occurred in the transport layer.
+type Code int
+
+// All available error codes.
+const (
+	// ConnectionClose denotes a connection that has been closed locally.
+	// Neither NetConn nor WebSocketConn returns it: after a local Close,
+	// Send fails with ConnectionError and Receive with NetworkError.
+	ConnectionClose Code = iota + 1
+
+	// DialError is returned by a Dialer if the connection could not be
+	// established at all.
+	DialError
+
+	// LaunchError is returned by Launch if a server could not be created.
+	LaunchError
+
+	// EncodeError is returned by Send if the supplied packet could not be
+	// encoded.
+	EncodeError
+
+	// DecodeError is returned by Receive if the read bytes could not be
+	// decoded into a packet.
+	DecodeError
+
+	// DetectionError is returned by Receive if the length of the next
+	// packet could not be detected.
+	DetectionError
+
+	// NetworkError is returned by Send or Receive if there was a network
+	// related error while reading or writing.
+	NetworkError
+
+	// ConnectionError is returned by Send if the connection has already
+	// been closed.
+	ConnectionError
+
+	// ExpectedClose is returned by Receive if the connection has been
+	// closed as expected.
+	ExpectedClose
+
+	// ReadLimitExceeded is returned by Receive if the next packet is
+	// bigger than the currently set read limit.
+	ReadLimitExceeded
+
+	// ContextCanceled is returned by SendContext or ReceiveContext if the
+	// supplied context was canceled or its deadline exceeded before the
+	// operation completed.
+	ContextCanceled
+
+	// KeepAliveTimeout is returned by Receive or ReceiveContext if
+	// SetKeepAlive was enabled and no response to a keepalive ping (or any
+	// other inbound activity) arrived within the configured timeout.
+	KeepAliveTimeout
+)
+
+// A TransportError wraps an underlying error and tags it with a Code so
+// callers can react to specific failure modes.
+type TransportError struct {
+	code Code
+	err  error
+}
+
+// newTransportError creates a new TransportError.
+func newTransportError(code Code, err error) *TransportError {
+	return &TransportError{
+		code: code,
+		err:  err,
+	}
+}
+
+// Code returns the error code.
+func (e *TransportError) Code() Code {
+	return e.code
+}
+
+// Err returns the underlying error.
+func (e *TransportError) Err() error {
+	return e.err
+}
+
+// Error returns a string representation of the error.
+func (e *TransportError) Error() string {
+	switch e.code {
+	case ConnectionClose:
+		return fmt.Sprintf("connection close: %s", e.err.Error())
+	case DialError:
+		return fmt.Sprintf("dial error: %s", e.err.Error())
+	case LaunchError:
+		return fmt.Sprintf("launch error: %s", e.err.Error())
+	case EncodeError:
+		return fmt.Sprintf("encode error: %s", e.err.Error())
+	case DecodeError:
+		return fmt.Sprintf("decode error: %s", e.err.Error())
+	case DetectionError:
+		return fmt.Sprintf("detection error: %s", e.err.Error())
+	case NetworkError:
+		return fmt.Sprintf("network error: %s", e.err.Error())
+	case ConnectionError:
+		return fmt.Sprintf("connection error: %s", e.err.Error())
+	case ExpectedClose:
+		return fmt.Sprintf("expected close: %s", e.err.Error())
+	case ReadLimitExceeded:
+		return fmt.Sprintf("read limit exceeded: %s", e.err.Error())
+	case ContextCanceled:
+		return fmt.Sprintf("context canceled: %s", e.err.Error())
+	case KeepAliveTimeout:
+		return fmt.Sprintf("keep alive timeout: %s", e.err.Error())
+	default:
+		return fmt.Sprintf("unknown error: %s", e.err.Error())
+	}
+}
+
+// toError converts a plain error into a TransportError, defaulting to
+// NetworkError if it isn't already one.
+func toError(err error) *TransportError {
+	if err == nil {
+		return nil
+	}
+
+	if transportError, ok := err.(*TransportError); ok {
+		return transportError
+	}
+
+	return newTransportError(NetworkError, err)
+}
@@ -43,6 +43,12 @@ func TestErrorStrings(t *testing.T) {
 	err = newTransportError(NetworkError, fmt.Errorf("foo"))
 	assert.Equal(t, "network error: foo", err.Error())
 
+	err = newTransportError(ContextCanceled, fmt.Errorf("foo"))
+	assert.Equal(t, "context canceled: foo", err.Error())
+
+	err = newTransportError(KeepAliveTimeout, fmt.Errorf("foo"))
+	assert.Equal(t, "keep alive timeout: foo", err.Error())
+
 	err = newTransportError(0, fmt.Errorf("foo"))
 	assert.Equal(t, "unknown error: foo", err.Error())
 }
@@ -0,0 +1,384 @@
+// Copyright (c) 2014 The gomqtt Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gomqtt/packet"
+)
+
+// NetConn is a Conn that operates on plain TCP or TLS connections.
+type NetConn struct {
+	conn  net.Conn
+	dec   *bufio.Reader
+	codec Codec
+
+	sendMutex  sync.Mutex
+	writer     io.Writer
+	bufWriter  *bufio.Writer
+	maxLatency time.Duration
+	flushTimer *time.Timer
+
+	keepAliveMu       sync.Mutex
+	keepAliveInterval time.Duration
+	keepAliveTimeout  time.Duration
+	pingTimer         *time.Timer
+	pingDeadline      *time.Timer
+	keepAliveErr      *TransportError
+
+	readLimit    int64
+	bytesRead    int64
+	bytesWritten int64
+}
+
+// NewNetConn wraps the given net.Conn and returns a Conn that sends and
+// receives MQTT packets.
+func NewNetConn(conn net.Conn) *NetConn {
+	return NewNetConnWithCodec(conn, MQTTCodec{})
+}
+
+// NewNetConnWithCodec wraps the given net.Conn and returns a Conn that
+// sends and receives packets using codec, e.g. MQTTCodec or CoAPCodec.
+func NewNetConnWithCodec(conn net.Conn, codec Codec) *NetConn {
+	c := &NetConn{
+		conn:  conn,
+		codec: codec,
+	}
+
+	c.dec = bufio.NewReader(&livenessReader{Conn: conn, onRead: c.onInboundActivity})
+	c.writer = &countingWriter{w: conn, n: &c.bytesWritten}
+
+	return c
+}
+
+// livenessReader wraps a net.Conn and invokes onRead after every Read that
+// returns at least one byte, letting SetKeepAlive treat any inbound byte
+// as evidence that the peer is still alive.
+type livenessReader struct {
+	net.Conn
+	onRead func()
+}
+
+func (r *livenessReader) Read(buf []byte) (int, error) {
+	n, err := r.Conn.Read(buf)
+	if n > 0 {
+		r.onRead()
+	}
+	return n, err
+}
+
+// countingWriter wraps an io.Writer and atomically adds every successful
+// Write's byte count to n, after the wrapped Write has returned.
+type countingWriter struct {
+	w io.Writer
+	n *int64
+}
+
+func (w *countingWriter) Write(buf []byte) (int, error) {
+	n, err := w.w.Write(buf)
+	atomic.AddInt64(w.n, int64(n))
+	return n, err
+}
+
+// WriteBuffer installs a buffer of size bytes that Send writes packets
+// into instead of the underlying connection directly, coalescing many
+// small packets into fewer, larger writes. The buffer is flushed
+// automatically once it fills, and otherwise after maxLatency has elapsed
+// since it was first written to, bounding how long a packet can sit
+// unsent. A maxLatency of zero disables the timer, leaving flushing to
+// buffer pressure and explicit Flush calls.
+func (c *NetConn) WriteBuffer(size int, maxLatency time.Duration) {
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+
+	c.bufWriter = bufio.NewWriterSize(&countingWriter{w: c.conn, n: &c.bytesWritten}, size)
+	c.writer = c.bufWriter
+	c.maxLatency = maxLatency
+}
+
+// Send will encode and write the packet to the underlying connection. It is
+// equivalent to calling SendContext with context.Background().
+func (c *NetConn) Send(pkt Packet) error {
+	return c.SendContext(context.Background(), pkt)
+}
+
+// SendContext is like Send but aborts with a TransportError of code
+// ContextCanceled if ctx is done before the packet has been fully written.
+func (c *NetConn) SendContext(ctx context.Context, pkt Packet) error {
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+
+	stop := c.watchContext(ctx, c.conn.SetWriteDeadline)
+	defer stop()
+
+	_, err := c.codec.Encode(c.writer, pkt)
+	if err != nil {
+		if transportErr, ok := err.(*TransportError); ok {
+			return transportErr
+		}
+
+		if ctx.Err() != nil {
+			return newTransportError(ContextCanceled, ctx.Err())
+		}
+
+		return newTransportError(ConnectionError, err)
+	}
+
+	if c.bufWriter != nil {
+		c.armFlushTimer()
+	}
+
+	if c.keepAliveInterval > 0 {
+		c.keepAliveMu.Lock()
+		c.armPingTimerLocked()
+		c.keepAliveMu.Unlock()
+	}
+
+	return nil
+}
+
+// SetKeepAlive enables the MQTT keepalive protocol: once the write side
+// has been idle for interval, a PINGREQ is sent, and the connection is
+// closed with a TransportError of code KeepAliveTimeout if no inbound
+// byte (normally the broker's PINGRESP) arrives within timeout of that
+// ping. SetKeepAlive only makes sense for connections using MQTTCodec.
+func (c *NetConn) SetKeepAlive(interval, timeout time.Duration) {
+	c.keepAliveMu.Lock()
+	defer c.keepAliveMu.Unlock()
+
+	c.keepAliveInterval = interval
+	c.keepAliveTimeout = timeout
+
+	c.armPingTimerLocked()
+}
+
+// armPingTimerLocked (re)starts the ping interval timer. Must be called
+// with keepAliveMu held.
+func (c *NetConn) armPingTimerLocked() {
+	if c.pingTimer != nil {
+		c.pingTimer.Stop()
+	}
+
+	if c.keepAliveInterval <= 0 {
+		return
+	}
+
+	c.pingTimer = time.AfterFunc(c.keepAliveInterval, c.sendPing)
+}
+
+// sendPing sends a PINGREQ and, unless a ping is already outstanding, arms
+// the timeout timer that closes the connection if no response arrives in
+// time. A ping that goes out while another is still awaiting a response
+// must not push the deadline back out, or a dead peer that never answers
+// would keep the connection alive forever.
+func (c *NetConn) sendPing() {
+	if err := c.Send(packet.NewPingreqPacket()); err != nil {
+		return
+	}
+
+	// push the PINGREQ out even if a WriteBuffer would otherwise hold it
+	if err := c.Flush(); err != nil {
+		return
+	}
+
+	c.keepAliveMu.Lock()
+	defer c.keepAliveMu.Unlock()
+
+	if c.pingDeadline == nil {
+		c.pingDeadline = time.AfterFunc(c.keepAliveTimeout, c.keepAliveTimedOut)
+	}
+}
+
+// onInboundActivity cancels the keepalive timeout timer, since any inbound
+// byte is evidence that the peer is still alive.
+func (c *NetConn) onInboundActivity() {
+	c.keepAliveMu.Lock()
+	defer c.keepAliveMu.Unlock()
+
+	if c.pingDeadline != nil {
+		c.pingDeadline.Stop()
+		c.pingDeadline = nil
+	}
+}
+
+// keepAliveTimedOut records a KeepAliveTimeout error and closes the
+// connection, which causes a blocked Receive to surface it.
+func (c *NetConn) keepAliveTimedOut() {
+	c.keepAliveMu.Lock()
+	c.keepAliveErr = newTransportError(KeepAliveTimeout, errors.New("no response to keepalive ping"))
+	c.keepAliveMu.Unlock()
+
+	c.conn.Close()
+}
+
+// armFlushTimer starts the max-latency flush timer if one is configured
+// and not already running. Must be called with sendMutex held.
+func (c *NetConn) armFlushTimer() {
+	if c.maxLatency <= 0 || c.flushTimer != nil {
+		return
+	}
+
+	c.flushTimer = time.AfterFunc(c.maxLatency, func() {
+		c.Flush()
+	})
+}
+
+// Flush writes any packets buffered by WriteBuffer to the underlying
+// connection. It is a no-op on a connection that has no write buffer
+// installed.
+func (c *NetConn) Flush() error {
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+
+	return c.flushLocked()
+}
+
+// flushLocked must be called with sendMutex held.
+func (c *NetConn) flushLocked() error {
+	if c.flushTimer != nil {
+		c.flushTimer.Stop()
+		c.flushTimer = nil
+	}
+
+	if c.bufWriter == nil {
+		return nil
+	}
+
+	if err := c.bufWriter.Flush(); err != nil {
+		return newTransportError(ConnectionError, err)
+	}
+
+	return nil
+}
+
+// Receive will read and decode the next packet from the underlying
+// connection. It is equivalent to calling ReceiveContext with
+// context.Background().
+func (c *NetConn) Receive() (Packet, error) {
+	return c.ReceiveContext(context.Background())
+}
+
+// ReceiveContext is like Receive but aborts with a TransportError of code
+// ContextCanceled if ctx is done before a packet has been fully read.
+func (c *NetConn) ReceiveContext(ctx context.Context) (Packet, error) {
+	stop := c.watchContext(ctx, c.conn.SetReadDeadline)
+	defer stop()
+
+	pkt, n, err := c.codec.Decode(c.dec, c.readLimit)
+	atomic.AddInt64(&c.bytesRead, int64(n))
+
+	if err != nil {
+		if transportErr, ok := err.(*TransportError); ok {
+			c.conn.Close()
+			return nil, transportErr
+		}
+
+		return nil, c.closeError(ctx, err)
+	}
+
+	return pkt, nil
+}
+
+// watchContext arms setDeadline(time.Now()) to unblock an in-flight
+// Read/Write as soon as ctx is done, and disarms it again once the
+// returned stop function is called. It is a no-op for a context that can
+// never be canceled (e.g. context.Background()).
+func (c *NetConn) watchContext(ctx context.Context, setDeadline func(time.Time) error) func() {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+
+	stop := make(chan struct{})
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			setDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+
+	return func() {
+		close(stop)
+		setDeadline(time.Time{})
+	}
+}
+
+// Close flushes any buffered packets and closes the underlying connection.
+func (c *NetConn) Close() error {
+	c.sendMutex.Lock()
+	c.flushLocked()
+	c.sendMutex.Unlock()
+
+	c.keepAliveMu.Lock()
+	if c.pingTimer != nil {
+		c.pingTimer.Stop()
+	}
+	if c.pingDeadline != nil {
+		c.pingDeadline.Stop()
+	}
+	c.keepAliveMu.Unlock()
+
+	return c.conn.Close()
+}
+
+// BytesRead returns the number of bytes already read from the underlying
+// connection.
+func (c *NetConn) BytesRead() int64 {
+	return atomic.LoadInt64(&c.bytesRead)
+}
+
+// BytesWritten returns the number of bytes already written to the
+// underlying connection.
+func (c *NetConn) BytesWritten() int64 {
+	return atomic.LoadInt64(&c.bytesWritten)
+}
+
+// SetReadLimit sets the maximum size of a packet that can be read from the
+// underlying connection.
+func (c *NetConn) SetReadLimit(limit int64) {
+	c.readLimit = limit
+}
+
+// closeError turns a raw read error into the appropriate TransportError,
+// distinguishing an expected close, a keepalive timeout, a canceled
+// context and a genuine network failure.
+func (c *NetConn) closeError(ctx context.Context, err error) error {
+	c.keepAliveMu.Lock()
+	keepAliveErr := c.keepAliveErr
+	c.keepAliveMu.Unlock()
+
+	if keepAliveErr != nil {
+		return keepAliveErr
+	}
+
+	if err == io.EOF {
+		return newTransportError(ExpectedClose, err)
+	}
+
+	if ctx.Err() != nil {
+		return newTransportError(ContextCanceled, ctx.Err())
+	}
+
+	return newTransportError(NetworkError, err)
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2014 The gomqtt Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gomqtt/packet"
+)
+
+const benchPacketCount = 10000
+
+func newPublishBenchPacket() *packet.PublishPacket {
+	pkt := packet.NewPublishPacket()
+	pkt.Message.Topic = []byte("bench")
+	pkt.Message.Payload = make([]byte, 32)
+
+	return pkt
+}
+
+// benchmarkNetConnSend sends benchPacketCount PUBLISH packets, each with a
+// 32 byte payload, over a net.Pipe, draining the other end as fast as possible so the benchmark
+// measures Send/Flush overhead rather than pipe backpressure.
+func benchmarkNetConnSend(b *testing.B, bufferSize int) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	go io.Copy(io.Discard, server)
+
+	conn := NewNetConn(client)
+
+	if bufferSize > 0 {
+		conn.WriteBuffer(bufferSize, 10*time.Millisecond)
+	}
+
+	pkt := newPublishBenchPacket()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < benchPacketCount; j++ {
+			if err := conn.Send(pkt); err != nil {
+				b.Fatal(err)
+			}
+		}
+
+		if err := conn.Flush(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkNetConnSendImmediate(b *testing.B) {
+	benchmarkNetConnSend(b, 0)
+}
+
+func BenchmarkNetConnSendCoalesced(b *testing.B) {
+	benchmarkNetConnSend(b, 32*1024)
+}
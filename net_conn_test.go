@@ -0,0 +1,93 @@
+// Copyright (c) 2014 The gomqtt Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"net"
+	"testing"
+)
+
+// netPreparer builds an abstractTestPreparer that connects a NetConn pair
+// over an in-memory pipe, sending and receiving packets using codec.
+func netPreparer(codec Codec) abstractTestPreparer {
+	return func(handler Handler) (Conn, chan struct{}) {
+		conn1, conn2 := net.Pipe()
+
+		done := make(chan struct{})
+
+		go func() {
+			handler(NewNetConnWithCodec(conn1, codec))
+			close(done)
+		}()
+
+		return NewNetConnWithCodec(conn2, codec), done
+	}
+}
+
+func TestNetConnConnect(t *testing.T) {
+	abstractConnConnectTest(t, netPreparer(MQTTCodec{}), mqttFixture)
+}
+
+func TestNetConnClose(t *testing.T) {
+	abstractConnCloseTest(t, netPreparer(MQTTCodec{}))
+}
+
+func TestNetConnEncodeError(t *testing.T) {
+	abstractConnEncodeErrorTest(t, netPreparer(MQTTCodec{}))
+}
+
+func TestNetConnDecodeError1(t *testing.T) {
+	abstractConnDecodeError1Test(t, netPreparer(MQTTCodec{}))
+}
+
+func TestNetConnDecodeError2(t *testing.T) {
+	abstractConnDecodeError2Test(t, netPreparer(MQTTCodec{}))
+}
+
+func TestNetConnDecodeError3(t *testing.T) {
+	abstractConnDecodeError3Test(t, netPreparer(MQTTCodec{}))
+}
+
+func TestNetConnSendAfterClose(t *testing.T) {
+	abstractConnSendAfterCloseTest(t, netPreparer(MQTTCodec{}))
+}
+
+func TestNetConnCounters(t *testing.T) {
+	abstractConnCountersTest(t, netPreparer(MQTTCodec{}), mqttFixture)
+}
+
+func TestNetConnReadLimit(t *testing.T) {
+	abstractConnReadLimitTest(t, netPreparer(MQTTCodec{}), mqttFixture)
+}
+
+func TestNetConnKeepAlive(t *testing.T) {
+	abstractConnKeepAliveTest(t, netPreparer(MQTTCodec{}))
+}
+
+func TestNetConnCoAPConnect(t *testing.T) {
+	abstractConnConnectTest(t, netPreparer(CoAPCodec{}), coapFixture)
+}
+
+func TestNetConnCoAPClose(t *testing.T) {
+	abstractConnCloseTest(t, netPreparer(CoAPCodec{}))
+}
+
+func TestNetConnCoAPCounters(t *testing.T) {
+	abstractConnCountersTest(t, netPreparer(CoAPCodec{}), coapFixture)
+}
+
+func TestNetConnCoAPReadLimit(t *testing.T) {
+	abstractConnReadLimitTest(t, netPreparer(CoAPCodec{}), coapFixture)
+}
@@ -0,0 +1,217 @@
+// Copyright (c) 2014 The gomqtt Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// ServerOptions configures a Server created by Launch.
+type ServerOptions struct {
+	// TLSConfig is used for tls and wss listeners.
+	TLSConfig *tls.Config
+
+	// Compression configures the permessage-deflate extension for ws and
+	// wss listeners. A nil value disables it.
+	Compression *CompressionOptions
+
+	// Codec selects the wire protocol spoken over accepted connections. A
+	// nil value defaults to MQTTCodec{}.
+	Codec Codec
+}
+
+// codec returns the configured Codec, defaulting to MQTTCodec{}.
+func (o *ServerOptions) codec() Codec {
+	if o.Codec != nil {
+		return o.Codec
+	}
+
+	return MQTTCodec{}
+}
+
+// A Server accepts incoming Conns.
+type Server interface {
+	// Accept returns the next Conn, blocking until one becomes available.
+	Accept() (Conn, error)
+
+	// Close stops the server from accepting further connections.
+	Close() error
+
+	// Addr returns the address the server is listening on.
+	Addr() net.Addr
+}
+
+// Launch creates a Server that listens on the given URL. The scheme must
+// be one of tcp, tls (or its aliases ssl and tcps), ws or wss.
+func Launch(urlString string, opts *ServerOptions) (Server, error) {
+	u, err := url.ParseRequestURI(urlString)
+	if err != nil {
+		return nil, newTransportError(LaunchError, err)
+	}
+
+	if opts == nil {
+		opts = &ServerOptions{}
+	}
+
+	switch u.Scheme {
+	case "tcp":
+		return launchNetServer(u.Host, nil, opts.codec())
+	case "tls", "ssl", "tcps":
+		return launchNetServer(u.Host, opts.TLSConfig, opts.codec())
+	case "ws":
+		return launchWebSocketServer(u.Host, nil, opts)
+	case "wss":
+		return launchWebSocketServer(u.Host, opts.TLSConfig, opts)
+	default:
+		return nil, newTransportError(LaunchError, fmt.Errorf("unsupported scheme %q", u.Scheme))
+	}
+}
+
+// netServer is a Server backed by a plain or TLS secured net.Listener.
+type netServer struct {
+	listener net.Listener
+	codec    Codec
+}
+
+func launchNetServer(addr string, tlsConfig *tls.Config, codec Codec) (*netServer, error) {
+	var listener net.Listener
+	var err error
+
+	if tlsConfig != nil {
+		listener, err = tls.Listen("tcp", addr, tlsConfig)
+	} else {
+		listener, err = net.Listen("tcp", addr)
+	}
+
+	if err != nil {
+		return nil, newTransportError(LaunchError, err)
+	}
+
+	return &netServer{listener: listener, codec: codec}, nil
+}
+
+func (s *netServer) Accept() (Conn, error) {
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return nil, newTransportError(NetworkError, err)
+	}
+
+	return NewNetConnWithCodec(conn, s.codec), nil
+}
+
+func (s *netServer) Close() error {
+	return s.listener.Close()
+}
+
+func (s *netServer) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// webSocketServer is a Server backed by an http.Server that upgrades every
+// request to a WebSocket connection.
+type webSocketServer struct {
+	listener    net.Listener
+	httpServer  *http.Server
+	upgrader    *websocket.Upgrader
+	compression *CompressionOptions
+	codec       Codec
+	conns       chan Conn
+	errs        chan error
+}
+
+func launchWebSocketServer(addr string, tlsConfig *tls.Config, opts *ServerOptions) (*webSocketServer, error) {
+	var listener net.Listener
+	var err error
+
+	if tlsConfig != nil {
+		listener, err = tls.Listen("tcp", addr, tlsConfig)
+	} else {
+		listener, err = net.Listen("tcp", addr)
+	}
+
+	if err != nil {
+		return nil, newTransportError(LaunchError, err)
+	}
+
+	s := &webSocketServer{
+		listener:    listener,
+		compression: opts.Compression,
+		codec:       opts.codec(),
+		conns:       make(chan Conn),
+		errs:        make(chan error, 1),
+	}
+
+	s.upgrader = &websocket.Upgrader{
+		Subprotocols: []string{"mqtt"},
+		CheckOrigin:  func(r *http.Request) bool { return true },
+	}
+
+	if s.compression != nil && s.compression.Enabled {
+		s.upgrader.EnableCompression = true
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handle)
+
+	s.httpServer = &http.Server{
+		Handler: mux,
+	}
+
+	go func() {
+		s.errs <- s.httpServer.Serve(s.listener)
+	}()
+
+	return s, nil
+}
+
+func (s *webSocketServer) handle(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	compressionEnabled := s.compression != nil && s.compression.Enabled &&
+		strings.Contains(r.Header.Get("Sec-WebSocket-Extensions"), "permessage-deflate")
+
+	if compressionEnabled && s.compression.Level != 0 {
+		conn.SetCompressionLevel(s.compression.Level)
+	}
+
+	s.conns <- NewWebSocketConnWithCodec(conn, compressionEnabled, s.codec)
+}
+
+func (s *webSocketServer) Accept() (Conn, error) {
+	select {
+	case conn := <-s.conns:
+		return conn, nil
+	case err := <-s.errs:
+		return nil, newTransportError(NetworkError, err)
+	}
+}
+
+func (s *webSocketServer) Close() error {
+	return s.httpServer.Close()
+}
+
+func (s *webSocketServer) Addr() net.Addr {
+	return s.listener.Addr()
+}
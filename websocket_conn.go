@@ -0,0 +1,468 @@
+// Copyright (c) 2014 The gomqtt Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// pingWriteTimeout bounds how long a keepalive ping control frame may take
+// to write before it is considered a failed liveness check.
+const pingWriteTimeout = 5 * time.Second
+
+// WebSocketConn is a Conn that operates on top of a WebSocket connection.
+//
+// Without a WriteBuffer, every packet is sent as a single binary WebSocket
+// message; with one, several whole packets may share a message. If the
+// connection negotiated the permessage-deflate extension (RFC 7692), the
+// gorilla websocket package transparently compresses and decompresses the
+// frames on the wire; BytesRead and BytesWritten still count the
+// decompressed packet-payload bytes, not the smaller compressed size that
+// actually went out over the socket, so they mean the same thing as on a
+// NetConn.
+type WebSocketConn struct {
+	conn               *websocket.Conn
+	codec              Codec
+	compressionEnabled bool
+
+	sendMutex    sync.Mutex
+	sendBuf      *bytes.Buffer
+	writeBufSize int
+	maxLatency   time.Duration
+	flushTimer   *time.Timer
+
+	keepAliveMu       sync.Mutex
+	keepAliveInterval time.Duration
+	keepAliveTimeout  time.Duration
+	pingTimer         *time.Timer
+	pingDeadline      *time.Timer
+	keepAliveErr      *TransportError
+
+	readLimit    int64
+	bytesRead    int64
+	bytesWritten int64
+	pending      []Packet
+}
+
+// NewWebSocketConn wraps the given gorilla websocket.Conn and returns a
+// Conn that sends and receives MQTT packets. compressionEnabled reports
+// whether permessage-deflate was negotiated during the handshake that
+// produced conn.
+func NewWebSocketConn(conn *websocket.Conn, compressionEnabled bool) *WebSocketConn {
+	return NewWebSocketConnWithCodec(conn, compressionEnabled, MQTTCodec{})
+}
+
+// NewWebSocketConnWithCodec is like NewWebSocketConn but sends and
+// receives packets using codec, e.g. MQTTCodec or CoAPCodec.
+func NewWebSocketConnWithCodec(conn *websocket.Conn, compressionEnabled bool, codec Codec) *WebSocketConn {
+	return &WebSocketConn{
+		conn:               conn,
+		codec:              codec,
+		compressionEnabled: compressionEnabled,
+	}
+}
+
+// CompressionEnabled returns whether permessage-deflate was negotiated for
+// this connection.
+func (c *WebSocketConn) CompressionEnabled() bool {
+	return c.compressionEnabled
+}
+
+// SetCompressionLevel sets the flate compression level used when writing
+// messages on a connection that has negotiated permessage-deflate. It has
+// no effect if compression was not negotiated.
+func (c *WebSocketConn) SetCompressionLevel(level int) error {
+	return c.conn.SetCompressionLevel(level)
+}
+
+// WriteBuffer enables coalescing of small packets into fewer, larger
+// WebSocket messages. Packets are appended to an internal buffer and sent
+// as a single binary message once the buffer reaches size bytes, once
+// Flush is called explicitly, or after maxLatency has elapsed since the
+// buffer was first written to, whichever comes first. A maxLatency of
+// zero disables the timer, leaving flushing to buffer pressure and
+// explicit Flush calls. A packet is never split across messages; each
+// message holds one or more whole packets, and ReceiveContext returns every
+// packet a message holds before reading the next one.
+func (c *WebSocketConn) WriteBuffer(size int, maxLatency time.Duration) {
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+
+	c.sendBuf = bytes.NewBuffer(make([]byte, 0, size))
+	c.writeBufSize = size
+	c.maxLatency = maxLatency
+}
+
+// Send will encode and write the packet to the underlying connection. It is
+// equivalent to calling SendContext with context.Background().
+func (c *WebSocketConn) Send(pkt Packet) error {
+	return c.SendContext(context.Background(), pkt)
+}
+
+// SendContext is like Send but aborts with a TransportError of code
+// ContextCanceled if ctx is done before the packet has been fully written.
+// Because the underlying gorilla connection cannot interrupt an in-flight
+// write by only changing its deadline, cancellation force-closes the
+// connection.
+func (c *WebSocketConn) SendContext(ctx context.Context, pkt Packet) error {
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+
+	var buf bytes.Buffer
+
+	if _, err := c.codec.Encode(&buf, pkt); err != nil {
+		if transportErr, ok := err.(*TransportError); ok {
+			return transportErr
+		}
+
+		return newTransportError(EncodeError, err)
+	}
+
+	if c.sendBuf != nil {
+		c.sendBuf.Write(buf.Bytes())
+
+		if c.sendBuf.Len() >= c.writeBufSize {
+			return c.flushLocked(ctx)
+		}
+
+		c.armFlushTimer()
+
+		return nil
+	}
+
+	return c.writeFrame(ctx, buf.Bytes())
+}
+
+// writeFrame writes payload as a single binary WebSocket message.
+func (c *WebSocketConn) writeFrame(ctx context.Context, payload []byte) error {
+	stop := c.watchContext(ctx)
+	defer stop()
+
+	if err := c.conn.WriteMessage(websocket.BinaryMessage, payload); err != nil {
+		if ctx.Err() != nil {
+			return newTransportError(ContextCanceled, ctx.Err())
+		}
+
+		return newTransportError(ConnectionError, err)
+	}
+
+	atomic.AddInt64(&c.bytesWritten, int64(len(payload)))
+
+	if c.keepAliveInterval > 0 {
+		c.keepAliveMu.Lock()
+		c.armPingTimerLocked()
+		c.keepAliveMu.Unlock()
+	}
+
+	return nil
+}
+
+// SetKeepAlive enables WebSocket-level keepalive: once the write side has
+// been idle for interval, a ping control frame is sent, and the connection
+// is closed with a TransportError of code KeepAliveTimeout if no pong, or
+// any other inbound activity, arrives within timeout of that ping.
+func (c *WebSocketConn) SetKeepAlive(interval, timeout time.Duration) {
+	c.keepAliveMu.Lock()
+	defer c.keepAliveMu.Unlock()
+
+	c.keepAliveInterval = interval
+	c.keepAliveTimeout = timeout
+
+	c.conn.SetPingHandler(func(appData string) error {
+		c.onInboundActivity()
+
+		c.sendMutex.Lock()
+		defer c.sendMutex.Unlock()
+
+		return c.conn.WriteControl(websocket.PongMessage, []byte(appData), time.Now().Add(pingWriteTimeout))
+	})
+
+	c.conn.SetPongHandler(func(string) error {
+		c.onInboundActivity()
+		return nil
+	})
+
+	c.armPingTimerLocked()
+}
+
+// armPingTimerLocked (re)starts the ping interval timer. Must be called
+// with keepAliveMu held.
+func (c *WebSocketConn) armPingTimerLocked() {
+	if c.pingTimer != nil {
+		c.pingTimer.Stop()
+	}
+
+	if c.keepAliveInterval <= 0 {
+		return
+	}
+
+	c.pingTimer = time.AfterFunc(c.keepAliveInterval, c.sendPing)
+}
+
+// sendPing writes a ping control frame, re-arms the interval timer so
+// keepalive keeps checking liveness for as long as the connection stays
+// idle, and, unless a ping is already outstanding, arms the timeout timer
+// that closes the connection if no pong arrives in time. A ping that goes
+// out while another is still awaiting a response must not push the
+// deadline back out, or a dead peer that never answers would keep the
+// connection alive forever.
+func (c *WebSocketConn) sendPing() {
+	c.sendMutex.Lock()
+	err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(pingWriteTimeout))
+	c.sendMutex.Unlock()
+
+	if err != nil {
+		return
+	}
+
+	c.keepAliveMu.Lock()
+	defer c.keepAliveMu.Unlock()
+
+	c.armPingTimerLocked()
+
+	if c.pingDeadline == nil {
+		c.pingDeadline = time.AfterFunc(c.keepAliveTimeout, c.keepAliveTimedOut)
+	}
+}
+
+// onInboundActivity cancels the keepalive timeout timer, since any inbound
+// activity is evidence that the peer is still alive.
+func (c *WebSocketConn) onInboundActivity() {
+	c.keepAliveMu.Lock()
+	defer c.keepAliveMu.Unlock()
+
+	if c.pingDeadline != nil {
+		c.pingDeadline.Stop()
+		c.pingDeadline = nil
+	}
+}
+
+// keepAliveTimedOut records a KeepAliveTimeout error and closes the
+// connection, which causes a blocked Receive to surface it.
+func (c *WebSocketConn) keepAliveTimedOut() {
+	c.keepAliveMu.Lock()
+	c.keepAliveErr = newTransportError(KeepAliveTimeout, errors.New("no pong to keepalive ping"))
+	c.keepAliveMu.Unlock()
+
+	c.conn.Close()
+}
+
+// armFlushTimer starts the max-latency flush timer if one is configured
+// and not already running. Must be called with sendMutex held.
+func (c *WebSocketConn) armFlushTimer() {
+	if c.maxLatency <= 0 || c.flushTimer != nil {
+		return
+	}
+
+	c.flushTimer = time.AfterFunc(c.maxLatency, func() {
+		c.Flush()
+	})
+}
+
+// Flush writes any packets buffered by WriteBuffer to the underlying
+// connection. It is a no-op on a connection that has no write buffer
+// installed.
+func (c *WebSocketConn) Flush() error {
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+
+	return c.flushLocked(context.Background())
+}
+
+// flushLocked must be called with sendMutex held.
+func (c *WebSocketConn) flushLocked(ctx context.Context) error {
+	if c.flushTimer != nil {
+		c.flushTimer.Stop()
+		c.flushTimer = nil
+	}
+
+	if c.sendBuf == nil || c.sendBuf.Len() == 0 {
+		return nil
+	}
+
+	payload := append([]byte(nil), c.sendBuf.Bytes()...)
+	c.sendBuf.Reset()
+
+	return c.writeFrame(ctx, payload)
+}
+
+// Receive will read and decode the next packet from the underlying
+// connection. It is equivalent to calling ReceiveContext with
+// context.Background().
+func (c *WebSocketConn) Receive() (Packet, error) {
+	return c.ReceiveContext(context.Background())
+}
+
+// ReceiveContext is like Receive but aborts with a TransportError of code
+// ContextCanceled if ctx is done before a packet has been fully read.
+//
+// A message coalesced by the sender's WriteBuffer may hold more than one
+// packet; ReceiveContext decodes all of them up front and returns them one
+// at a time, only reading the next message once they have been drained.
+func (c *WebSocketConn) ReceiveContext(ctx context.Context) (Packet, error) {
+	if len(c.pending) > 0 {
+		pkt := c.pending[0]
+		c.pending = c.pending[1:]
+		return pkt, nil
+	}
+
+	stop := c.watchContext(ctx)
+	defer stop()
+
+	_, buf, err := c.conn.ReadMessage()
+	if err != nil {
+		return nil, c.closeError(ctx, err)
+	}
+
+	atomic.AddInt64(&c.bytesRead, int64(len(buf)))
+
+	if c.keepAliveInterval > 0 {
+		c.onInboundActivity()
+	}
+
+	if c.readLimit > 0 && int64(len(buf)) > c.readLimit {
+		c.conn.Close()
+		return nil, newTransportError(ReadLimitExceeded, io.ErrShortBuffer)
+	}
+
+	r := bytes.NewReader(buf)
+
+	var pkts []Packet
+
+	for r.Len() > 0 {
+		pkt, _, err := c.codec.Decode(r, 0)
+		if err != nil {
+			c.conn.Close()
+
+			if transportErr, ok := err.(*TransportError); ok {
+				return nil, transportErr
+			}
+
+			return nil, newTransportError(DecodeError, err)
+		}
+
+		pkts = append(pkts, pkt)
+	}
+
+	if len(pkts) == 0 {
+		c.conn.Close()
+		return nil, newTransportError(DetectionError, errors.New("websocket: empty message"))
+	}
+
+	c.pending = pkts[1:]
+
+	return pkts[0], nil
+}
+
+// watchContext force-closes the connection as soon as ctx is done, and
+// disarms itself once the returned stop function is called. It is a no-op
+// for a context that can never be canceled (e.g. context.Background()).
+func (c *WebSocketConn) watchContext(ctx context.Context) func() {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+
+	stop := make(chan struct{})
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.conn.Close()
+		case <-stop:
+		}
+	}()
+
+	return func() {
+		close(stop)
+	}
+}
+
+// Close flushes any buffered packets and closes the underlying connection.
+func (c *WebSocketConn) Close() error {
+	c.sendMutex.Lock()
+	c.flushLocked(context.Background())
+	c.sendMutex.Unlock()
+
+	c.keepAliveMu.Lock()
+	if c.pingTimer != nil {
+		c.pingTimer.Stop()
+	}
+	if c.pingDeadline != nil {
+		c.pingDeadline.Stop()
+	}
+	c.keepAliveMu.Unlock()
+
+	return c.conn.Close()
+}
+
+// BytesRead returns the number of (decompressed) packet-payload bytes
+// already read from the underlying connection.
+func (c *WebSocketConn) BytesRead() int64 {
+	return atomic.LoadInt64(&c.bytesRead)
+}
+
+// BytesWritten returns the number of (decompressed) packet-payload bytes
+// already written to the underlying connection.
+func (c *WebSocketConn) BytesWritten() int64 {
+	return atomic.LoadInt64(&c.bytesWritten)
+}
+
+// SetReadLimit sets the maximum size of a (decompressed) packet that can be
+// read from the underlying connection. This is enforced entirely by
+// ReceiveContext against the decompressed message length; gorilla's own
+// read limit is deliberately left unset, since it is checked against the
+// message as read off the wire and would fail the connection with a plain
+// error (surfaced as NetworkError) before ReceiveContext ever gets to
+// compare the decompressed length against limit. When the peer coalesces
+// packets with WriteBuffer, the limit applies to the whole message rather
+// than to each packet in it.
+func (c *WebSocketConn) SetReadLimit(limit int64) {
+	c.readLimit = limit
+}
+
+// closeError turns a raw websocket read error into the appropriate
+// TransportError, distinguishing an expected close, a keepalive timeout, a
+// canceled context and a genuine network failure.
+func (c *WebSocketConn) closeError(ctx context.Context, err error) error {
+	c.keepAliveMu.Lock()
+	keepAliveErr := c.keepAliveErr
+	c.keepAliveMu.Unlock()
+
+	if keepAliveErr != nil {
+		return keepAliveErr
+	}
+
+	if _, ok := err.(*websocket.CloseError); ok {
+		return newTransportError(ExpectedClose, err)
+	}
+
+	if err == io.EOF {
+		return newTransportError(ExpectedClose, err)
+	}
+
+	if ctx.Err() != nil {
+		return newTransportError(ContextCanceled, ctx.Err())
+	}
+
+	return newTransportError(NetworkError, err)
+}
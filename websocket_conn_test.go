@@ -0,0 +1,157 @@
+// Copyright (c) 2014 The gomqtt Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"fmt"
+	"testing"
+)
+
+// wsPreparer builds an abstractTestPreparer that dials a freshly launched
+// WebSocket server, optionally negotiating permessage-deflate on both ends
+// so the abstract suite can be run once uncompressed and once compressed,
+// sending and receiving packets using codec.
+func wsPreparer(compress bool, codec Codec) abstractTestPreparer {
+	return func(handler Handler) (Conn, chan struct{}) {
+		opts := &ServerOptions{Codec: codec}
+		if compress {
+			opts.Compression = &CompressionOptions{Enabled: true}
+		}
+
+		server, err := Launch("ws://localhost:0", opts)
+		if err != nil {
+			panic(err)
+		}
+
+		done := make(chan struct{})
+
+		go func() {
+			conn1, err := server.Accept()
+			if err != nil {
+				return
+			}
+
+			handler(conn1)
+
+			server.Close()
+			close(done)
+		}()
+
+		dialer := NewDialer()
+		dialer.Codec = codec
+		if compress {
+			dialer.Compression = &CompressionOptions{Enabled: true}
+		}
+
+		conn2, err := dialer.Dial(fmt.Sprintf("ws://%s", server.Addr().String()))
+		if err != nil {
+			panic(err)
+		}
+
+		return conn2, done
+	}
+}
+
+func TestWebSocketConnConnect(t *testing.T) {
+	abstractConnConnectTest(t, wsPreparer(false, MQTTCodec{}), mqttFixture)
+}
+
+func TestWebSocketConnClose(t *testing.T) {
+	abstractConnCloseTest(t, wsPreparer(false, MQTTCodec{}))
+}
+
+func TestWebSocketConnEncodeError(t *testing.T) {
+	abstractConnEncodeErrorTest(t, wsPreparer(false, MQTTCodec{}))
+}
+
+func TestWebSocketConnDecodeError1(t *testing.T) {
+	abstractConnDecodeError1Test(t, wsPreparer(false, MQTTCodec{}))
+}
+
+func TestWebSocketConnDecodeError2(t *testing.T) {
+	abstractConnDecodeError2Test(t, wsPreparer(false, MQTTCodec{}))
+}
+
+func TestWebSocketConnDecodeError3(t *testing.T) {
+	abstractConnDecodeError3Test(t, wsPreparer(false, MQTTCodec{}))
+}
+
+func TestWebSocketConnSendAfterClose(t *testing.T) {
+	abstractConnSendAfterCloseTest(t, wsPreparer(false, MQTTCodec{}))
+}
+
+func TestWebSocketConnCounters(t *testing.T) {
+	abstractConnCountersTest(t, wsPreparer(false, MQTTCodec{}), mqttFixture)
+}
+
+func TestWebSocketConnReadLimit(t *testing.T) {
+	abstractConnReadLimitTest(t, wsPreparer(false, MQTTCodec{}), mqttFixture)
+}
+
+func TestWebSocketConnKeepAlive(t *testing.T) {
+	abstractConnKeepAliveTest(t, wsPreparer(false, MQTTCodec{}))
+}
+
+func TestWebSocketConnCompressedConnect(t *testing.T) {
+	abstractConnConnectTest(t, wsPreparer(true, MQTTCodec{}), mqttFixture)
+}
+
+func TestWebSocketConnCompressedClose(t *testing.T) {
+	abstractConnCloseTest(t, wsPreparer(true, MQTTCodec{}))
+}
+
+func TestWebSocketConnCompressedCounters(t *testing.T) {
+	abstractConnCountersTest(t, wsPreparer(true, MQTTCodec{}), mqttFixture)
+}
+
+func TestWebSocketConnCompressedReadLimit(t *testing.T) {
+	abstractConnReadLimitTest(t, wsPreparer(true, MQTTCodec{}), mqttFixture)
+}
+
+func TestWebSocketConnCoAPConnect(t *testing.T) {
+	abstractConnConnectTest(t, wsPreparer(false, CoAPCodec{}), coapFixture)
+}
+
+func TestWebSocketConnCoAPClose(t *testing.T) {
+	abstractConnCloseTest(t, wsPreparer(false, CoAPCodec{}))
+}
+
+func TestWebSocketConnCoAPCounters(t *testing.T) {
+	abstractConnCountersTest(t, wsPreparer(false, CoAPCodec{}), coapFixture)
+}
+
+func TestWebSocketConnCoAPReadLimit(t *testing.T) {
+	abstractConnReadLimitTest(t, wsPreparer(false, CoAPCodec{}), coapFixture)
+}
+
+func TestWebSocketConnCompressionEnabled(t *testing.T) {
+	conn2, done := wsPreparer(true, MQTTCodec{})(func(conn1 Conn) {
+		wsConn, ok := conn1.(*WebSocketConn)
+		if !ok || !wsConn.CompressionEnabled() {
+			t.Errorf("expected compression to be negotiated on server side")
+		}
+
+		conn1.Close()
+	})
+
+	wsConn, ok := conn2.(*WebSocketConn)
+	if !ok || !wsConn.CompressionEnabled() {
+		t.Errorf("expected compression to be negotiated on client side")
+	}
+
+	conn2.Receive()
+
+	<-done
+}